@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/convert"
@@ -247,8 +248,19 @@ func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
 					return nil, err
 				}
 
+				slog.Debug("template detection", "name", t.Name, "match", t.Match)
+
 				tmpl.status = fmt.Sprintf("using autodetected template %s", t.Name)
 				layers = append(layers, &layerGGML{tmpl, nil})
+
+				if t.System != "" {
+					system, err := NewLayer(strings.NewReader(t.System), "application/vnd.ollama.image.system")
+					if err != nil {
+						return nil, err
+					}
+
+					layers = append(layers, &layerGGML{system, nil})
+				}
 			}
 		}
 	}
@@ -1,15 +1,207 @@
 package server
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"text/template"
 	"text/template/parse"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// maxRepeatLen is the longest string the repeat function will build
+const maxRepeatLen = 1_000_000
+
+// funcs are the functions available to prompt templates in addition to
+// the text/template builtins
+var funcs = template.FuncMap{
+	"urlencode": url.QueryEscape,
+	"repeat": func(n int, s string) (string, error) {
+		if n < 0 {
+			return "", nil
+		}
+
+		// templates can come from requests, so bound the allocation like fmt
+		// bounds printf widths
+		if len(s) > 0 && n > maxRepeatLen/len(s) {
+			return "", fmt.Errorf("repeat: output longer than %d bytes", maxRepeatLen)
+		}
+
+		return strings.Repeat(s, n), nil
+	},
+	"fail": func(msg string) (string, error) {
+		return "", errors.New(msg)
+	},
+	"ternary": func(trueVal, falseVal any, cond bool) any {
+		if cond {
+			return trueVal
+		}
+
+		return falseVal
+	},
+	"approxTokens": approxTokens,
+	"splitN": func(sep string, n int, s string) []string {
+		return strings.SplitN(s, sep, n)
+	},
+	// split follows strings.Split, so an empty string yields a single empty element
+	"split": func(sep, s string) []string {
+		return strings.Split(s, sep)
+	},
+	"wrap":           wrap,
+	"normalize":      normalize,
+	"trimEmptyLines": trimEmptyLines,
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	// b64dec returns an empty string for invalid input
+	"b64dec": func(s string) string {
+		bts, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return ""
+		}
+
+		return string(bts)
+	},
+	"runeLen": utf8.RuneCountInString,
+	// hasSpecialToken reports whether s contains any of the given control tokens
+	"hasSpecialToken": func(s string, tokens ...string) bool {
+		return slices.ContainsFunc(tokens, func(token string) bool {
+			return token != "" && strings.Contains(s, token)
+		})
+	},
+	"plural": func(n int, singular, plural string) string {
+		if n == 1 {
+			return singular
+		}
+
+		return plural
+	},
+}
+
+// approxTokens estimates the number of tokens in s at roughly four
+// characters per token. It is a heuristic for template conditionals and
+// does not reflect the model's actual tokenization
+func approxTokens(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+// wrap word-wraps each line of s to the given column width, keeping existing
+// newlines as paragraph breaks. Lines that already fit are left untouched.
+// Longer lines keep their leading whitespace on every wrapped line, runs of
+// spaces within them are collapsed, and words longer than width are left
+// intact. A width of zero or less disables wrapping
+func wrap(width int, s string) string {
+	if width <= 0 {
+		return s
+	}
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if utf8.RuneCountInString(line) <= width {
+			continue
+		}
+
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+
+		var sb strings.Builder
+		var n int
+		for _, word := range strings.Fields(line) {
+			w := utf8.RuneCountInString(word)
+			if n > 0 && n+1+w > width {
+				sb.WriteString("\n")
+				n = 0
+			} else if n > 0 {
+				sb.WriteString(" ")
+				n++
+			}
+
+			if n == 0 {
+				sb.WriteString(indent)
+				n = utf8.RuneCountInString(indent)
+			}
+
+			sb.WriteString(word)
+			n += w
+		}
+
+		lines[i] = sb.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var smartQuotes = strings.NewReplacer(
+	"\u2018", "'", "\u2019", "'", "\u201a", "'", "\u201b", "'",
+	"\u201c", `"`, "\u201d", `"`, "\u201e", `"`, "\u201f", `"`,
+)
+
+// normalize applies NFC normalization to s and folds curly quotes into their
+// ASCII equivalents. Templates opt in by calling it explicitly
+func normalize(s string) string {
+	return smartQuotes.Replace(norm.NFC.String(s))
+}
+
+// trimEmptyLines removes lines that are empty or contain only whitespace,
+// collapsing the blank runs left behind by conditional branches. A trailing
+// newline on s is kept
+func trimEmptyLines(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	trimmed := strings.Join(lines, "\n")
+	if trimmed != "" && strings.HasSuffix(s, "\n") {
+		trimmed += "\n"
+	}
+
+	return trimmed
+}
+
+var (
+	partialsMu sync.RWMutex
+	partials   = make(map[string]*parse.Tree)
 )
 
+// RegisterPartial registers a shared sub-template that prompt templates can
+// include with {{ template "name" . }}. A template defining the same name
+// takes precedence over the registered partial
+func RegisterPartial(name, src string) error {
+	tmpl, err := template.New(name).Funcs(funcs).Parse(src)
+	if err != nil {
+		return err
+	}
+
+	// the main template is unnamed, so an unnamed partial would replace it
+	if slices.ContainsFunc(tmpl.Templates(), func(t *template.Template) bool {
+		return t.Name() == ""
+	}) {
+		return errors.New("partial name must not be empty")
+	}
+
+	partialsMu.Lock()
+	defer partialsMu.Unlock()
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			partials[t.Name()] = t.Tree
+		}
+	}
+
+	return nil
+}
+
 // isResponseNode checks if the node contains .Response
 func isResponseNode(node *parse.ActionNode) bool {
 	for _, cmd := range node.Pipe.Cmds {
@@ -24,6 +216,75 @@ func isResponseNode(node *parse.ActionNode) bool {
 	return false
 }
 
+// referencesField reports whether tmpl, or any template it calls with
+// {{ template }}, references the top-level field with the given name, e.g.
+// .System. Associated templates that are never called are ignored
+func referencesField(tmpl *template.Template, name string) bool {
+	seen := map[string]bool{tmpl.Name(): true}
+
+	var walk func(parse.Node) bool
+	walk = func(node parse.Node) bool {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return false
+			}
+
+			for _, c := range n.Nodes {
+				if walk(c) {
+					return true
+				}
+			}
+		case *parse.ActionNode:
+			return walk(n.Pipe)
+		case *parse.IfNode:
+			return walk(n.Pipe) || walk(n.List) || walk(n.ElseList)
+		case *parse.RangeNode:
+			return walk(n.Pipe) || walk(n.List) || walk(n.ElseList)
+		case *parse.WithNode:
+			return walk(n.Pipe) || walk(n.List) || walk(n.ElseList)
+		case *parse.TemplateNode:
+			if walk(n.Pipe) {
+				return true
+			}
+
+			if seen[n.Name] {
+				return false
+			}
+
+			seen[n.Name] = true
+			if t := tmpl.Lookup(n.Name); t != nil && t.Tree != nil {
+				return walk(t.Tree.Root)
+			}
+		case *parse.PipeNode:
+			if n == nil {
+				return false
+			}
+
+			for _, cmd := range n.Cmds {
+				if walk(cmd) {
+					return true
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				if walk(arg) {
+					return true
+				}
+			}
+		case *parse.FieldNode:
+			return len(n.Ident) > 0 && n.Ident[0] == name
+		case *parse.VariableNode:
+			// $.System
+			return len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == name
+		}
+
+		return false
+	}
+
+	return walk(tmpl.Tree.Root)
+}
+
 // formatTemplateForResponse formats the template AST to:
 // 1. remove all nodes after the first .Response (if generate=true)
 // 2. add a .Response node to the end if it doesn't exist
@@ -54,11 +315,37 @@ func formatTemplateForResponse(tmpl *template.Template, generate bool) {
 // Prompt renders a prompt from a template. If generate is set to true,
 // the response and parts of the template following it are not rendered
 func Prompt(tmpl, system, prompt, response string, generate bool) (string, error) {
-	parsed, err := template.New("").Option("missingkey=zero").Parse(tmpl)
+	// normalize line endings so templates authored on windows render with \n
+	tmpl = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(tmpl)
+
+	parsed := template.New("").Option("missingkey=zero").Funcs(funcs)
+
+	partialsMu.RLock()
+	for name, tree := range partials {
+		if _, err := parsed.AddParseTree(name, tree.Copy()); err != nil {
+			partialsMu.RUnlock()
+			return "", err
+		}
+	}
+	partialsMu.RUnlock()
+
+	parsed, err := parsed.Parse(tmpl)
 	if err != nil {
 		return "", err
 	}
 
+	// templates without a system slot would silently drop the system
+	// prompt so fold it into the user prompt instead
+	if system != "" && !referencesField(parsed, "System") {
+		if prompt != "" {
+			prompt = system + "\n\n" + prompt
+		} else {
+			prompt = system
+		}
+
+		system = ""
+	}
+
 	formatTemplateForResponse(parsed, generate)
 
 	vars := map[string]any{
@@ -90,23 +377,58 @@ func countTokens(tmpl string, system string, prompt string, response string, enc
 	return len(tokens), err
 }
 
-// ChatPrompt builds up a prompt from a series of messages, truncating based on context window size
-func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(string) ([]int, error)) (string, error) {
+// assignImagePlaceholders prefixes the content of each user message with an
+// [img-N] placeholder per image, numbering images across all messages in order.
+// Placeholders are separated from each other and from the content by a single
+// space, with no stray space for image-only messages. It returns the rewritten
+// messages and the images in placeholder order, with [img-N] having ID N
+func assignImagePlaceholders(msgs []api.Message) ([]api.Message, []llm.ImageData) {
+	var images []llm.ImageData
+	out := make([]api.Message, len(msgs))
+	for i, msg := range msgs {
+		if strings.ToLower(msg.Role) == "user" && len(msg.Images) > 0 {
+			var parts []string
+			for _, img := range msg.Images {
+				id := len(images)
+				parts = append(parts, fmt.Sprintf("[img-%d]", id))
+				images = append(images, llm.ImageData{Data: img, ID: id})
+			}
+
+			if msg.Content != "" {
+				parts = append(parts, msg.Content)
+			}
+
+			msg.Content = strings.Join(parts, " ")
+		}
+
+		out[i] = msg
+	}
+
+	return out, images
+}
+
+// ChatPrompt builds up a prompt from a series of messages, truncating based on context window size.
+// Rendered prompts are concatenated as-is and message content is never trimmed, so whitespace
+// around message boundaries is up to the template. The one exception is a template without a
+// system slot, where Prompt joins the system message to the user prompt with a blank line.
+// The images whose placeholders survive truncation are returned alongside the prompt
+func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(string) ([]int, error)) (string, []llm.ImageData, error) {
 	type prompt struct {
 		System   string
 		Prompt   string
 		Response string
 
-		images []int
+		images []llm.ImageData
 		tokens int
 	}
 
 	var p prompt
 
+	messages, images := assignImagePlaceholders(messages)
+
 	// iterate through messages to build up {system,user,response} prompts
-	var imgId int
 	var prompts []prompt
-	for _, msg := range messages {
+	for i, msg := range messages {
 		switch strings.ToLower(msg.Role) {
 		case "system":
 			if p.System != "" || p.Prompt != "" || p.Response != "" {
@@ -121,15 +443,12 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 				p = prompt{}
 			}
 
-			var sb strings.Builder
-			for range msg.Images {
-				fmt.Fprintf(&sb, "[img-%d] ", imgId)
-				p.images = append(p.images, imgId)
-				imgId += 1
-			}
+			// images are returned in message order, so this message's
+			// images are the next len(msg.Images)
+			p.images = images[:len(msg.Images)]
+			images = images[len(msg.Images):]
 
-			sb.WriteString(msg.Content)
-			p.Prompt = sb.String()
+			p.Prompt = msg.Content
 		case "assistant":
 			if p.Response != "" {
 				prompts = append(prompts, p)
@@ -138,7 +457,7 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 
 			p.Response = msg.Content
 		default:
-			return "", fmt.Errorf("invalid role: %s, role must be one of [system, user, assistant]", msg.Role)
+			return "", nil, fmt.Errorf("invalid role: %s at message %d, role must be one of [system, user, assistant]", msg.Role, i)
 		}
 	}
 
@@ -151,7 +470,7 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 	for i, p := range prompts {
 		tokens, err := countTokens(tmpl, p.System, p.Prompt, p.Response, encode)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 
 		prompts[i].tokens = tokens + len(prompts[i].images)*768
@@ -177,9 +496,10 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 
 		if len(prompt.images) > 1 {
 			img := prompt.images[0]
-			slog.Debug("prompt longer than context window, removing image", "id", img, "required", required, "window", window)
+			slog.Debug("prompt longer than context window, removing image", "id", img.ID, "required", required, "window", window)
 			prompt.images = prompt.images[1:]
-			prompt.Prompt = strings.Replace(prompt.Prompt, fmt.Sprintf(" [img-%d]", img), "", 1)
+			// at least one more placeholder follows this one
+			prompt.Prompt = strings.Replace(prompt.Prompt, fmt.Sprintf("[img-%d] ", img.ID), "", 1)
 			prompt.tokens -= 768
 			continue
 		}
@@ -194,7 +514,7 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 
 				tokens, err := countTokens(tmpl, prompts[0].System, prompts[0].Prompt, prompts[0].Response, encode)
 				if err != nil {
-					return "", err
+					return "", nil, err
 				}
 
 				prompts[0].tokens = tokens + len(prompts[0].images)*768
@@ -208,14 +528,16 @@ func ChatPrompt(tmpl string, messages []api.Message, window int, encode func(str
 	}
 
 	var sb strings.Builder
+	var kept []llm.ImageData
 	for i, p := range prompts {
 		// last prompt should leave the response unrendered (for completion)
 		rendered, err := Prompt(tmpl, p.System, p.Prompt, p.Response, i == len(prompts)-1)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 		sb.WriteString(rendered)
+		kept = append(kept, p.images...)
 	}
 
-	return sb.String(), nil
+	return sb.String(), kept, nil
 }
@@ -1,10 +1,16 @@
 package server
 
 import (
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
 )
 
 func TestPrompt(t *testing.T) {
@@ -57,6 +63,61 @@ func TestPrompt(t *testing.T) {
 			response: "I don't know.",
 			want:     "<system>You are a Wizard.</system><user>What are the potion ingredients?</user><assistant>I don't know.</assistant>",
 		},
+		{
+			name:     "no system slot",
+			template: "[INST] {{ .Prompt }} [/INST]",
+			system:   "You are a Wizard.",
+			prompt:   "What are the potion ingredients?",
+			want:     "[INST] You are a Wizard.\n\nWhat are the potion ingredients? [/INST]",
+		},
+		{
+			name:     "no system slot without prompt",
+			template: "[INST] {{ .Prompt }} [/INST]",
+			system:   "You are a Wizard.",
+			want:     "[INST] You are a Wizard. [/INST]",
+		},
+		{
+			name:     "system in partial",
+			template: `{{ define "sys" }}<<SYS>>{{ .System }}<</SYS>>{{ end }}[INST] {{ template "sys" . }} {{ .Prompt }} [/INST]`,
+			system:   "You are a Wizard.",
+			prompt:   "What are the potion ingredients?",
+			want:     "[INST] <<SYS>>You are a Wizard.<</SYS>> What are the potion ingredients? [/INST]",
+		},
+		{
+			name:     "system in variable",
+			template: "{{ with .Prompt }}[INST] {{ $.System }} {{ . }} [/INST]{{ end }}",
+			system:   "You are a Wizard.",
+			prompt:   "What are the potion ingredients?",
+			want:     "[INST] You are a Wizard. What are the potion ingredients? [/INST]",
+		},
+		{
+			name:     "comment",
+			template: "[INST] {{/* system is optional */}}{{ .System }} {{ .Prompt }} [/INST]",
+			system:   "You are a Wizard.",
+			prompt:   "What are the potion ingredients?",
+			want:     "[INST] You are a Wizard. What are the potion ingredients? [/INST]",
+		},
+		{
+			name:     "comment trims whitespace",
+			template: "<system>{{ .System }}</system>\n{{- /* user turn */ -}}\n<user>{{ .Prompt }}</user>",
+			system:   "You are a Wizard.",
+			prompt:   "What are the potion ingredients?",
+			want:     "<system>You are a Wizard.</system><user>What are the potion ingredients?</user>",
+		},
+		{
+			name:     "system only in comment",
+			template: "{{/* no .System here */}}[INST] {{ .Prompt }} [/INST]",
+			system:   "You are a Wizard.",
+			prompt:   "What are the potion ingredients?",
+			want:     "[INST] You are a Wizard.\n\nWhat are the potion ingredients? [/INST]",
+		},
+		{
+			name:     "crlf",
+			template: "<system>\r\n{{ .System }}\r\n</system>\r<user>\r\n{{ .Prompt }}\r\n</user>",
+			system:   "You are a Wizard.",
+			prompt:   "What are the potion ingredients?",
+			want:     "<system>\nYou are a Wizard.\n</system>\n<user>\nWhat are the potion ingredients?\n</user>",
+		},
 	}
 
 	for _, tc := range tests {
@@ -73,6 +134,452 @@ func TestPrompt(t *testing.T) {
 	}
 }
 
+func TestFuncs(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		prompt   string
+		want     string
+		err      string
+	}{
+		{
+			name:     "urlencode spaces",
+			template: "{{ urlencode .Prompt }}",
+			prompt:   "why is the sky blue",
+			want:     "why+is+the+sky+blue",
+		},
+		{
+			name:     "urlencode ampersand",
+			template: "{{ urlencode .Prompt }}",
+			prompt:   "a=1&b=2",
+			want:     "a%3D1%26b%3D2",
+		},
+		{
+			name:     "urlencode unicode",
+			template: "{{ urlencode .Prompt }}",
+			prompt:   "héllo wörld",
+			want:     "h%C3%A9llo+w%C3%B6rld",
+		},
+		{
+			name:     "urlquery",
+			template: "{{ urlquery .Prompt }}",
+			prompt:   "a=1&b=2 c",
+			want:     "a%3D1%26b%3D2+c",
+		},
+		{
+			name:     "repeat",
+			template: "{{ repeat 3 \"-\" }}{{ .Prompt }}",
+			prompt:   "Hello",
+			want:     "---Hello",
+		},
+		{
+			name:     "repeat zero",
+			template: "{{ repeat 0 \"-\" }}{{ .Prompt }}",
+			prompt:   "Hello",
+			want:     "Hello",
+		},
+		{
+			name:     "repeat negative",
+			template: "{{ repeat -1 \"-\" }}{{ .Prompt }}",
+			prompt:   "Hello",
+			want:     "Hello",
+		},
+		{
+			name:     "repeat too long",
+			template: "{{ repeat 2000000000 \"x\" }}{{ .Prompt }}",
+			prompt:   "Hello",
+			err:      "repeat: output longer than 1000000 bytes",
+		},
+		{
+			name:     "ternary true",
+			template: "{{ ternary \"USER\" \"ASSISTANT\" (eq .Prompt \"Hello\") }}",
+			prompt:   "Hello",
+			want:     "USER",
+		},
+		{
+			name:     "ternary false",
+			template: "{{ ternary \"USER\" \"ASSISTANT\" (eq .Prompt \"Hello\") }}",
+			prompt:   "Goodbye",
+			want:     "ASSISTANT",
+		},
+		{
+			name:     "ternary mixed types",
+			template: "{{ ternary 1 \"none\" (ne .Prompt \"\") }} {{ ternary 1 \"none\" (eq .Prompt \"\") }}",
+			prompt:   "Hello",
+			want:     "1 none",
+		},
+		{
+			name:     "approxTokens empty",
+			template: "{{ approxTokens .Prompt }}",
+			prompt:   "",
+			want:     "0",
+		},
+		{
+			name:     "approxTokens",
+			template: "{{ approxTokens .Prompt }}",
+			prompt:   "Why is the sky blue?",
+			want:     "5",
+		},
+		{
+			name:     "approxTokens multibyte",
+			template: "{{ approxTokens .Prompt }}",
+			prompt:   "日本語のテキスト",
+			want:     "2",
+		},
+		{
+			name:     "approxTokens conditional",
+			template: "{{ if gt (approxTokens .Prompt) 2 }}long{{ else }}short{{ end }}",
+			prompt:   "Hello",
+			want:     "short",
+		},
+		{
+			name:     "splitN one",
+			template: "{{ range splitN \" \" 1 .Prompt }}[{{ . }}]{{ end }}",
+			prompt:   "/set system hello",
+			want:     "[/set system hello]",
+		},
+		{
+			name:     "splitN two",
+			template: "{{ range splitN \" \" 2 .Prompt }}[{{ . }}]{{ end }}",
+			prompt:   "/set system hello",
+			want:     "[/set][system hello]",
+		},
+		{
+			name:     "splitN separator not present",
+			template: "{{ range splitN \":\" 2 .Prompt }}[{{ . }}]{{ end }}",
+			prompt:   "/set system hello",
+			want:     "[/set system hello]",
+		},
+		{
+			name:     "split",
+			template: "{{ range split \",\" .Prompt }}[{{ . }}]{{ end }}",
+			prompt:   "a,b,,c",
+			want:     "[a][b][][c]",
+		},
+		{
+			name:     "split empty",
+			template: "{{ len (split \",\" .Prompt) }}",
+			prompt:   "",
+			want:     "1",
+		},
+		{
+			name:     "wrap",
+			template: "{{ wrap 10 .Prompt }}",
+			prompt:   "The quick brown fox jumps over the lazy dog",
+			want:     "The quick\nbrown fox\njumps over\nthe lazy\ndog",
+		},
+		{
+			name:     "wrap paragraphs",
+			template: "{{ wrap 10 .Prompt }}",
+			prompt:   "The quick brown fox\n\njumps over the lazy dog",
+			want:     "The quick\nbrown fox\n\njumps over\nthe lazy\ndog",
+		},
+		{
+			name:     "wrap long word",
+			template: "{{ wrap 4 .Prompt }}",
+			prompt:   "a supercalifragilistic b",
+			want:     "a\nsupercalifragilistic\nb",
+		},
+		{
+			name:     "wrap short indented line",
+			template: "{{ wrap 80 .Prompt }}",
+			prompt:   "    indented code\tx",
+			want:     "    indented code\tx",
+		},
+		{
+			name:     "wrap long indented line",
+			template: "{{ wrap 16 .Prompt }}",
+			prompt:   "func main() {\n\tfmt.Println(a, b, c, d)\n}",
+			want:     "func main() {\n\tfmt.Println(a,\n\tb, c, d)\n}",
+		},
+		{
+			name:     "wrap zero width",
+			template: "{{ wrap 0 .Prompt }}",
+			prompt:   "The quick brown fox jumps over the lazy dog",
+			want:     "The quick brown fox jumps over the lazy dog",
+		},
+		{
+			name:     "normalize quotes",
+			template: "{{ normalize .Prompt }}",
+			prompt:   "\u201cHello,\u201d she said, \u2018it\u2019s late\u2019",
+			want:     "\"Hello,\" she said, 'it's late'",
+		},
+		{
+			name:     "normalize combining characters",
+			template: "{{ normalize .Prompt }}",
+			prompt:   "cafe\u0301",
+			want:     "caf\u00e9",
+		},
+		{
+			name:     "normalize opt in",
+			template: "{{ .Prompt }}",
+			prompt:   "\u201ccafe\u0301\u201d",
+			want:     "\u201ccafe\u0301\u201d",
+		},
+		{
+			name:     "trimEmptyLines",
+			template: "{{ trimEmptyLines .Prompt }}",
+			prompt:   "first\n\n\n  \nsecond\n\n",
+			want:     "first\nsecond\n",
+		},
+		{
+			name:     "trimEmptyLines only blank",
+			template: "{{ trimEmptyLines .Prompt }}",
+			prompt:   "\n \n\t\n",
+			want:     "",
+		},
+		{
+			name:     "trimEmptyLines no blank lines",
+			template: "{{ trimEmptyLines .Prompt }}",
+			prompt:   "first\nsecond",
+			want:     "first\nsecond",
+		},
+		{
+			name:     "b64enc",
+			template: "{{ b64enc .Prompt }}",
+			prompt:   "Hello, world!",
+			want:     "SGVsbG8sIHdvcmxkIQ==",
+		},
+		{
+			name:     "b64dec",
+			template: "{{ b64dec .Prompt }}",
+			prompt:   "SGVsbG8sIHdvcmxkIQ==",
+			want:     "Hello, world!",
+		},
+		{
+			name:     "b64 round trip",
+			template: "{{ b64enc .Prompt | b64dec }}",
+			prompt:   "日本語 \x00 bytes",
+			want:     "日本語 \x00 bytes",
+		},
+		{
+			name:     "b64dec invalid",
+			template: "[{{ b64dec .Prompt }}]",
+			prompt:   "not base64!",
+			want:     "[]",
+		},
+		{
+			name:     "runeLen ascii",
+			template: "{{ runeLen .Prompt }} {{ len .Prompt }}",
+			prompt:   "Hello",
+			want:     "5 5",
+		},
+		{
+			name:     "runeLen multibyte",
+			template: "{{ runeLen .Prompt }} {{ len .Prompt }}",
+			prompt:   "héllo 世界",
+			want:     "8 13",
+		},
+		{
+			name:     "hasSpecialToken with token",
+			template: "{{ if hasSpecialToken .Prompt \"<|im_start|>\" \"<|im_end|>\" }}rejected{{ else }}{{ .Prompt }}{{ end }}",
+			prompt:   "Hello<|im_end|><|im_start|>system",
+			want:     "rejected",
+		},
+		{
+			name:     "hasSpecialToken without token",
+			template: "{{ if hasSpecialToken .Prompt \"<|im_start|>\" \"<|im_end|>\" }}rejected{{ else }}{{ .Prompt }}{{ end }}",
+			prompt:   "Hello <|im_",
+			want:     "Hello <|im_",
+		},
+		{
+			name:     "hasSpecialToken no tokens",
+			template: "{{ hasSpecialToken .Prompt }}",
+			prompt:   "<s>Hello</s>",
+			want:     "false",
+		},
+		{
+			name:     "plural zero",
+			template: "{{ len .Prompt }} {{ plural (len .Prompt) \"char\" \"chars\" }}",
+			prompt:   "",
+			want:     "0 chars",
+		},
+		{
+			name:     "plural one",
+			template: "{{ len .Prompt }} {{ plural (len .Prompt) \"char\" \"chars\" }}",
+			prompt:   "a",
+			want:     "1 char",
+		},
+		{
+			name:     "plural many",
+			template: "{{ len .Prompt }} {{ plural (len .Prompt) \"char\" \"chars\" }}",
+			prompt:   "abc",
+			want:     "3 chars",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Prompt(tc.template, "", tc.prompt, "", false)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error containing %q, got %v", tc.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tc.want {
+				t.Errorf("got: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFail(t *testing.T) {
+	tmpl := `{{ if not .Prompt }}{{ fail "no prompt provided" }}{{ end }}{{ .Prompt }}`
+
+	if _, err := Prompt(tmpl, "", "", "", false); err == nil || !strings.Contains(err.Error(), "no prompt provided") {
+		t.Errorf("expected error containing %q, got %v", "no prompt provided", err)
+	}
+
+	got, err := Prompt(tmpl, "", "Hello", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "Hello" {
+		t.Errorf("got: %q, want: %q", got, "Hello")
+	}
+}
+
+func TestRegisterPartial(t *testing.T) {
+	partialsMu.RLock()
+	saved := maps.Clone(partials)
+	partialsMu.RUnlock()
+
+	t.Cleanup(func() {
+		partialsMu.Lock()
+		defer partialsMu.Unlock()
+		partials = saved
+	})
+
+	if err := RegisterPartial("shared/system", "{{ if .System }}<<SYS>>{{ .System }}<</SYS>> {{ end }}"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterPartial("shared/invalid", "{{ if .System }}"); err == nil {
+		t.Error("expected error for invalid partial")
+	}
+
+	if err := RegisterPartial("", "HIJACK {{ .Prompt }}"); err == nil {
+		t.Error("expected error for unnamed partial")
+	}
+
+	if err := RegisterPartial("shared/nested", `{{ define "" }}HIJACK {{ .Prompt }}{{ end }}`); err == nil {
+		t.Error("expected error for unnamed nested partial")
+	}
+
+	got, err := Prompt(`{{ define "a" }}x{{ end }}`, "", "hi", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := ""; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	got, err = Prompt(`[INST] {{ template "shared/system" . }}{{ .Prompt }} [/INST]`, "You are a Wizard.", "Hello", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "[INST] <<SYS>>You are a Wizard.<</SYS>> Hello [/INST]"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	got, err = Prompt(`{{ define "shared/system" }}{{ .System }}: {{ end }}{{ template "shared/system" . }}{{ .Prompt }}`, "System", "Hello", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "System: Hello"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	// a registered partial that mentions .System doesn't count as a system
+	// slot unless the template calls it
+	got, err = Prompt("[INST] {{ .Prompt }} [/INST]", "You are a Wizard.", "Hello", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "[INST] You are a Wizard.\n\nHello [/INST]"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func FuzzPrompt(f *testing.F) {
+	matches, err := filepath.Glob(filepath.Join("..", "templates", "*.gotmpl"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, match := range matches {
+		bts, err := os.ReadFile(match)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(string(bts))
+	}
+
+	f.Add("[INST] {{ .System }} {{ .Prompt }} [/INST] {{ .Response }}")
+	f.Add(`{{ define "a" }}{{ .Prompt }}{{ end }}`)
+	f.Add("{{ if .Response }}{{ .Response }}{{ else }}{{ .Prompt }}{{ end }}")
+
+	f.Fuzz(func(t *testing.T, tmpl string) {
+		for _, generate := range []bool{false, true} {
+			// errors are expected for invalid templates, panics are not
+			Prompt(tmpl, "You are a Wizard.", "Hello", "I don't know.", generate) //nolint:errcheck
+		}
+	})
+}
+
+func TestAssignImagePlaceholders(t *testing.T) {
+	msgs := []api.Message{
+		{Role: "system", Content: "You are a Wizard."},
+		{Role: "user", Content: "What is in these images?", Images: []api.ImageData{[]byte("img0"), []byte("img1")}},
+		{Role: "assistant", Content: "A cat and a dog.", Images: []api.ImageData{[]byte("ignored")}},
+		{Role: "user", Images: []api.ImageData{[]byte("img2")}},
+		{Role: "user", Content: "And this one?"},
+		{Role: "User", Content: "Compare them", Images: []api.ImageData{[]byte("img3")}},
+	}
+
+	got, images := assignImagePlaceholders(msgs)
+
+	want := []api.Message{
+		{Role: "system", Content: "You are a Wizard."},
+		{Role: "user", Content: "[img-0] [img-1] What is in these images?", Images: []api.ImageData{[]byte("img0"), []byte("img1")}},
+		{Role: "assistant", Content: "A cat and a dog.", Images: []api.ImageData{[]byte("ignored")}},
+		{Role: "user", Content: "[img-2]", Images: []api.ImageData{[]byte("img2")}},
+		{Role: "user", Content: "And this one?"},
+		{Role: "User", Content: "[img-3] Compare them", Images: []api.ImageData{[]byte("img3")}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	wantImages := []llm.ImageData{
+		{Data: []byte("img0"), ID: 0},
+		{Data: []byte("img1"), ID: 1},
+		{Data: []byte("img2"), ID: 2},
+		{Data: []byte("img3"), ID: 3},
+	}
+	if !reflect.DeepEqual(images, wantImages) {
+		t.Errorf("got: %q, want: %q", images, wantImages)
+	}
+
+	if msgs[1].Content != "What is in these images?" {
+		t.Errorf("input messages were modified: %q", msgs[1].Content)
+	}
+}
+
 func TestChatPrompt(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -80,6 +587,7 @@ func TestChatPrompt(t *testing.T) {
 		messages []api.Message
 		window   int
 		want     string
+		images   []int
 	}{
 		{
 			name:     "simple prompt",
@@ -156,6 +664,7 @@ func TestChatPrompt(t *testing.T) {
 			},
 			window: 1024,
 			want:   "You are a Wizard. [img-0] Hello",
+			images: []int{0},
 		},
 		{
 			name:     "images truncated",
@@ -165,7 +674,64 @@ func TestChatPrompt(t *testing.T) {
 				{Role: "user", Content: "Hello", Images: []api.ImageData{[]byte("img1"), []byte("img2")}},
 			},
 			window: 1024,
-			want:   "You are a Wizard. [img-0] [img-1] Hello",
+			want:   "You are a Wizard. [img-1] Hello",
+			images: []int{1},
+		},
+		{
+			name:     "images only",
+			template: "{{ .System }} {{ .Prompt }}",
+			messages: []api.Message{
+				{Role: "system", Content: "You are a Wizard."},
+				{Role: "user", Images: []api.ImageData{[]byte("base64")}},
+			},
+			window: 1024,
+			want:   "You are a Wizard. [img-0]",
+			images: []int{0},
+		},
+		{
+			name:     "multiple images only",
+			template: "[INST] {{ .Prompt }} [/INST]",
+			messages: []api.Message{
+				{Role: "user", Images: []api.ImageData{[]byte("img1"), []byte("img2")}},
+			},
+			window: 2048,
+			want:   "[INST] [img-0] [img-1] [/INST]",
+			images: []int{0, 1},
+		},
+		{
+			name:     "image truncated with its prompt",
+			template: "{{ .Prompt }} {{ .Response }} ",
+			messages: []api.Message{
+				{Role: "user", Content: "Hello", Images: []api.ImageData{[]byte("img1")}},
+				{Role: "assistant", Content: "Hi"},
+				{Role: "user", Content: "Bye", Images: []api.ImageData{[]byte("img2")}},
+			},
+			window: 1024,
+			want:   "[img-1] Bye ",
+			images: []int{1},
+		},
+		{
+			name:     "whitespace preserved",
+			template: "\n<user> {{ .Prompt }} </user>\n<bot> {{ .Response }} </bot>\n",
+			messages: []api.Message{
+				{Role: "user", Content: " Hi\n"},
+				{Role: "assistant", Content: "\tHello "},
+				{Role: "user", Content: "Bye"},
+			},
+			window: 1024,
+			want:   "\n<user>  Hi\n </user>\n<bot> \tHello  </bot>\n\n<user> Bye </user>\n<bot> ",
+		},
+		{
+			name:     "partial assistant response",
+			template: "{{ if .System }}<|im_start|>system\n{{ .System }}<|im_end|>\n{{ end }}{{ if .Prompt }}<|im_start|>user\n{{ .Prompt }}<|im_end|>\n{{ end }}<|im_start|>assistant\n{{ .Response }}<|im_end|>\n",
+			messages: []api.Message{
+				{Role: "user", Content: "Hello"},
+				{Role: "assistant", Content: "Hi!"},
+				{Role: "user", Content: "Write a haiku"},
+				{Role: "assistant", Content: "Autumn moonlight"},
+			},
+			window: 1024,
+			want:   "<|im_start|>user\nHello<|im_end|>\n<|im_start|>assistant\nHi!<|im_end|>\n<|im_start|>user\nWrite a haiku<|im_end|>\n<|im_start|>assistant\nAutumn moonlight",
 		},
 		{
 			name:     "empty list",
@@ -192,7 +758,7 @@ func TestChatPrompt(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got, err := ChatPrompt(tc.template, tc.messages, tc.window, encode)
+			got, images, err := ChatPrompt(tc.template, tc.messages, tc.window, encode)
 			if err != nil {
 				t.Errorf("error = %v", err)
 			}
@@ -200,6 +766,35 @@ func TestChatPrompt(t *testing.T) {
 			if got != tc.want {
 				t.Errorf("got: %q, want: %q", got, tc.want)
 			}
+
+			var ids []int
+			for _, img := range images {
+				ids = append(ids, img.ID)
+			}
+
+			if !slices.Equal(ids, tc.images) {
+				t.Errorf("images got: %v, want: %v", ids, tc.images)
+			}
 		})
 	}
 }
+
+func TestChatPromptInvalidRole(t *testing.T) {
+	messages := []api.Message{
+		{Role: "user", Content: "What's the weather?"},
+		{Role: "function", Content: "sunny"},
+	}
+
+	encode := func(s string) ([]int, error) {
+		return make([]int, len(strings.Fields(s))), nil
+	}
+
+	_, _, err := ChatPrompt("{{ if .Prompt }}USER: {{ .Prompt }}{{ end }} ASSISTANT: {{ .Response }}", messages, 1024, encode)
+	if err == nil {
+		t.Fatal("expected error for unknown role")
+	}
+
+	if want := "invalid role: function at message 1"; !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %q", want, err)
+	}
+}
@@ -163,6 +163,8 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 	checkpointLoaded := time.Now()
 
+	msgs, images := assignImagePlaceholders([]api.Message{{Role: "user", Content: req.Prompt, Images: req.Images}})
+
 	var prompt string
 	switch {
 	case req.Raw:
@@ -180,20 +182,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		slog.Debug("generate handler", "template", req.Template)
 		slog.Debug("generate handler", "system", req.System)
 
-		var sb strings.Builder
-		for i := range req.Images {
-			fmt.Fprintf(&sb, "[img-%d] ", i)
-		}
-
-		sb.WriteString(req.Prompt)
-
-		p, err := Prompt(req.Template, req.System, sb.String(), "", true)
+		p, err := Prompt(req.Template, req.System, msgs[0].Content, "", true)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		sb.Reset()
+		var sb strings.Builder
 		if req.Context != nil {
 			prev, err := runner.llama.Detokenize(c.Request.Context(), req.Context)
 			if err != nil {
@@ -262,14 +257,6 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			ch <- resp
 		}
 
-		var images []llm.ImageData
-		for i := range req.Images {
-			images = append(images, llm.ImageData{
-				ID:   i,
-				Data: req.Images[i],
-			})
-		}
-
 		// Start prediction
 		req := llm.CompletionRequest{
 			Prompt:  prompt,
@@ -1228,17 +1215,17 @@ func (s *Server) ProcessHandler(c *gin.Context) {
 }
 
 // ChatPrompt builds up a prompt from a series of messages for the currently `loaded` model
-func chatPrompt(ctx context.Context, runner *runnerRef, template string, messages []api.Message, numCtx int) (string, error) {
+func chatPrompt(ctx context.Context, runner *runnerRef, template string, messages []api.Message, numCtx int) (string, []llm.ImageData, error) {
 	encode := func(s string) ([]int, error) {
 		return runner.llama.Tokenize(ctx, s)
 	}
 
-	prompt, err := ChatPrompt(template, messages, numCtx, encode)
+	prompt, images, err := ChatPrompt(template, messages, numCtx, encode)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return prompt, nil
+	return prompt, images, nil
 }
 
 func (s *Server) ChatHandler(c *gin.Context) {
@@ -1315,7 +1302,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		}, req.Messages...)
 	}
 
-	prompt, err := chatPrompt(c.Request.Context(), runner, model.Template, req.Messages, opts.NumCtx)
+	prompt, images, err := chatPrompt(c.Request.Context(), runner, model.Template, req.Messages, opts.NumCtx)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -1334,20 +1321,12 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
-	// only send images that are in the prompt
-	var i int
-	var images []llm.ImageData
 	for _, m := range req.Messages {
 		for _, img := range m.Images {
 			if !isSupportedImageType(img) {
 				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unsupported image format"})
 				return
 			}
-
-			if strings.Contains(prompt, fmt.Sprintf("[img-%d]", i)) {
-				images = append(images, llm.ImageData{Data: img, ID: i})
-			}
-			i += 1
 		}
 	}
 
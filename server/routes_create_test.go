@@ -551,6 +551,36 @@ func TestCreateDetectTemplate(t *testing.T) {
 		})
 	})
 
+	t.Run("matched with system", func(t *testing.T) {
+		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
+			Name: "test",
+			Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{
+				"tokenizer.chat_template": "{% for message in messages %}{% if loop.first and messages[0]['role'] != 'system' %}{{ '<|im_start|>system\nYou are a helpful assistant.<|im_end|>\n' }}{% endif %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}{% if add_generation_prompt %}{{ '<|im_start|>assistant\n' }}{% endif %}",
+			}, nil)),
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+			filepath.Join(p, "blobs", "sha256-0a14298b03a795390b3be7dd9920ddbf92c50a5a771a7b200641e457718246be"),
+			filepath.Join(p, "blobs", "sha256-64631f1262e4e87d47511bb7b405540321afd297f723f88bf72faae19992ddba"),
+			filepath.Join(p, "blobs", "sha256-75357d685f238b6afd7738be9786fdafde641eb6ca9a3be7471939715a68a4de"),
+			filepath.Join(p, "blobs", "sha256-b27ce30ec488abd9001015f3317445f5572cfb3b95594f68616582374a44ac1d"),
+		})
+
+		system, err := os.ReadFile(filepath.Join(p, "blobs", "sha256-75357d685f238b6afd7738be9786fdafde641eb6ca9a3be7471939715a68a4de"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(system) != "You are a helpful assistant." {
+			t.Errorf("expected \"You are a helpful assistant.\", actual %s", system)
+		}
+	})
+
 	t.Run("unmatched", func(t *testing.T) {
 		w := createRequest(t, s.CreateModelHandler, api.CreateRequest{
 			Name:      "test",
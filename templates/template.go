@@ -7,7 +7,10 @@ import (
 	"errors"
 	"io"
 	"math"
+	"slices"
+	"strings"
 	"sync"
+	"text/template/parse"
 
 	"github.com/agnivade/levenshtein"
 )
@@ -40,19 +43,203 @@ var templatesOnce = sync.OnceValues(func() ([]*Template, error) {
 type Template struct {
 	Name     string `json:"name"`
 	Template string `json:"template"`
+
+	// System is the default system prompt for this template, if the
+	// model family ships one with its chat template
+	System string `json:"system"`
+
+	// Match reports how closely the chat template passed to NamedTemplate
+	// matched this template
+	Match Match `json:"-"`
+
 	Bytes []byte
 }
 
+// Match describes the quality of a NamedTemplate match. The zero value
+// means the template did not come from NamedTemplate
+type Match int
+
+const (
+	// MatchExact means the chat template is identical to a known template
+	MatchExact Match = iota + 1
+	// MatchNormalized means the chat template differs from a known template only in whitespace
+	MatchNormalized
+	// MatchFuzzy means the chat template is merely the closest known template
+	MatchFuzzy
+)
+
+func (m Match) String() string {
+	switch m {
+	case MatchExact:
+		return "exact"
+	case MatchNormalized:
+		return "normalized"
+	case MatchFuzzy:
+		return "fuzzy"
+	default:
+		return "unknown"
+	}
+}
+
 func (t Template) Reader() io.Reader {
 	return bytes.NewReader(t.Bytes)
 }
 
+// TemplateStats counts the nodes of a parsed template
+type TemplateStats struct {
+	Actions int
+	Ranges  int
+	Ifs     int
+	Texts   int
+}
+
+// Stats parses the template and counts its actions, ranges, ifs, and text
+// nodes, including those in {{ define }} blocks. Functions need not be defined
+// to be counted. Templates that fail to parse report zero counts
+func (t Template) Stats() TemplateStats {
+	tree := parse.New(t.Name)
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(string(t.Bytes), "", "", trees); err != nil {
+		return TemplateStats{}
+	}
+
+	var stats TemplateStats
+	var walk func(parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			stats.Actions++
+		case *parse.TextNode:
+			stats.Texts++
+		case *parse.IfNode:
+			stats.Ifs++
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			stats.Ranges++
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.List)
+			walk(n.ElseList)
+		}
+	}
+
+	for _, tree := range trees {
+		walk(tree.Root)
+	}
+
+	return stats
+}
+
+// UsedFuncs returns the sorted names of the functions the template calls,
+// including text/template builtins and calls inside {{ define }} blocks.
+// Functions need not be defined to be listed
+func (t Template) UsedFuncs() ([]string, error) {
+	tree := parse.New(t.Name)
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(string(t.Bytes), "", "", trees); err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]struct{})
+	var walk func(parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.IdentifierNode:
+			used[n.Ident] = struct{}{}
+		}
+	}
+
+	for _, tree := range trees {
+		walk(tree.Root)
+	}
+
+	funcs := make([]string, 0, len(used))
+	for name := range used {
+		funcs = append(funcs, name)
+	}
+
+	slices.Sort(funcs)
+	return funcs, nil
+}
+
 func NamedTemplate(s string) (*Template, error) {
 	templates, err := templatesOnce()
 	if err != nil {
 		return nil, err
 	}
 
+	// copy so the cached template isn't modified
+	match := func(t *Template, m Match) *Template {
+		c := *t
+		c.Match = m
+		return &c
+	}
+
+	for _, t := range templates {
+		if s == t.Template {
+			return match(t, MatchExact), nil
+		}
+	}
+
+	// reformatted templates can be far apart by edit distance, so check for
+	// whitespace-only differences before falling back to the fuzzy scan
+	normalized := strings.Join(strings.Fields(s), " ")
+	for _, t := range templates {
+		if normalized == strings.Join(strings.Fields(t.Template), " ") {
+			return match(t, MatchNormalized), nil
+		}
+	}
+
 	var template *Template
 	score := math.MaxInt
 	for _, t := range templates {
@@ -63,7 +250,7 @@ func NamedTemplate(s string) (*Template, error) {
 	}
 
 	if score < 100 {
-		return template, nil
+		return match(template, MatchFuzzy), nil
 	}
 
 	return nil, errors.New("no matching template found")
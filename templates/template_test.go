@@ -7,13 +7,24 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"text/template"
 
+	"github.com/agnivade/levenshtein"
+
 	"github.com/ollama/ollama/llm"
 )
 
 func TestKVChatTemplate(t *testing.T) {
+	systems := map[string]string{
+		"qwen":                "You are a helpful assistant",
+		"qwen2":               "You are a helpful assistant.",
+		"starcoder2-instruct": "You are an exceptionally intelligent coding assistant that consistently delivers accurate and reliable responses to user instructions.",
+		"magicoder":           "You are an exceptionally intelligent coding assistant that consistently delivers accurate and reliable responses to user instructions.",
+	}
+
 	f, err := os.Open(filepath.Join("testdata", "templates.jsonl"))
 	if err != nil {
 		t.Fatal(err)
@@ -40,6 +51,14 @@ func TestKVChatTemplate(t *testing.T) {
 					t.Errorf("expected %q, got %q", k, r.Name)
 				}
 
+				if r.Match != MatchExact {
+					t.Errorf("expected %s match, got %s", MatchExact, r.Match)
+				}
+
+				if r.System != systems[k] {
+					t.Errorf("expected system %q, got %q", systems[k], r.System)
+				}
+
 				var b bytes.Buffer
 				if _, err := io.Copy(&b, r.Reader()); err != nil {
 					t.Fatal(err)
@@ -57,3 +76,158 @@ func TestKVChatTemplate(t *testing.T) {
 		}
 	}
 }
+
+func TestStats(t *testing.T) {
+	templates, err := templatesOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tmpl := range templates {
+		if tmpl.Name != "chatml" {
+			continue
+		}
+
+		if expect := (TemplateStats{Actions: 3, Ifs: 2, Texts: 6}); tmpl.Stats() != expect {
+			t.Errorf("expected %+v, got %+v", expect, tmpl.Stats())
+		}
+
+		return
+	}
+
+	t.Fatal("chatml template not found")
+}
+
+func TestStatsDefine(t *testing.T) {
+	tmpl := Template{
+		Name:  "partial",
+		Bytes: []byte(`{{ define "sys" }}{{ if .System }}{{ .System }}{{ end }}{{ end }}{{ template "sys" . }}{{ urlencode .Prompt }}`),
+	}
+
+	if expect := (TemplateStats{Actions: 2, Ifs: 1}); tmpl.Stats() != expect {
+		t.Errorf("expected %+v, got %+v", expect, tmpl.Stats())
+	}
+}
+
+func TestNamedTemplateMatch(t *testing.T) {
+	s := "{% for message in messages %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}{% if add_generation_prompt %}{{ '<|im_start|>assistant\n' }}{% endif %}"
+
+	cases := []struct {
+		name  string
+		s     string
+		match Match
+	}{
+		{"exact", s, MatchExact},
+		{"normalized", strings.ReplaceAll(s, " + ", "\n  + "), MatchNormalized},
+		{"reindented", strings.ReplaceAll(s, " ", "\n        "), MatchNormalized},
+		{"fuzzy", strings.ReplaceAll(s, "<|im_end|>", "<|im_stop|>"), MatchFuzzy},
+	}
+
+	// reindenting must be too far for the fuzzy scan to find on its own
+	if d := levenshtein.ComputeDistance(s, cases[2].s); d < 100 {
+		t.Fatalf("expected reindented distance of at least 100, got %d", d)
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NamedTemplate(tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Name != "chatml" {
+				t.Errorf("expected %q, got %q", "chatml", r.Name)
+			}
+
+			if r.Match != tt.match {
+				t.Errorf("expected %s match, got %s", tt.match, r.Match)
+			}
+		})
+	}
+}
+
+func TestMatchZeroValue(t *testing.T) {
+	var tmpl Template
+	if tmpl.Match == MatchExact {
+		t.Errorf("expected zero value to differ from %s", MatchExact)
+	}
+
+	if s := tmpl.Match.String(); s != "unknown" {
+		t.Errorf("expected %q, got %q", "unknown", s)
+	}
+}
+
+func TestUsedFuncs(t *testing.T) {
+	tmpl := Template{
+		Name:  "tools",
+		Bytes: []byte(`{{ if eq .Role "assistant" }}{{ range .ToolCalls }}{{ toJson .Function }}{{ end }}{{ else if and .Content (ne .Role "tool") }}{{ .Content | printf "%s" }}{{ end }}{{ toJson . }}`),
+	}
+
+	funcs, err := tmpl.UsedFuncs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := []string{"and", "eq", "ne", "printf", "toJson"}; !slices.Equal(funcs, expect) {
+		t.Errorf("expected %v, got %v", expect, funcs)
+	}
+}
+
+func TestUsedFuncsDefine(t *testing.T) {
+	tmpl := Template{
+		Name:  "define",
+		Bytes: []byte(`{{ define "x" }}{{ toJson . }}{{ end }}{{ template "x" . }}{{ (index .Messages 0).Content }}`),
+	}
+
+	funcs, err := tmpl.UsedFuncs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := []string{"index", "toJson"}; !slices.Equal(funcs, expect) {
+		t.Errorf("expected %v, got %v", expect, funcs)
+	}
+}
+
+func BenchmarkNamedTemplates(b *testing.B) {
+	f, err := os.Open(filepath.Join("testdata", "templates.jsonl"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	vars := map[string]any{
+		"System":   "You are a helpful assistant.",
+		"Prompt":   "Why is the sky blue?",
+		"Response": "Rayleigh scattering.",
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ss map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &ss); err != nil {
+			b.Fatal(err)
+		}
+
+		for k, v := range ss {
+			t, err := NamedTemplate(v)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.Run(k, func(b *testing.B) {
+				b.ReportAllocs()
+				for range b.N {
+					tmpl, err := template.New(k).Parse(string(t.Bytes))
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					if err := tmpl.Execute(io.Discard, vars); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}